@@ -1,28 +1,102 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"regexp"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/yosisa/webutil"
 )
 
 const namespace = "lmq"
 
+// Populated at link time via -ldflags "-X main.version=... -X main.revision=... -X main.branch=...".
 var (
-	listenAddress = flag.String("web.listen-address", ":9001", "Address on which to expose metrics.")
-	metricsPath   = flag.String("web.metrics-path", "/metrics", "Path under which to expose metrics.")
-	minInterval   = flag.Duration("collector.min-interval", 5*time.Second, "Minimum update interval.")
-	lmqUri        = flag.String("lmq.uri", "http://localhost:9980/stats", "LMQ URI.")
+	version  = "dev"
+	revision = "unknown"
+	branch   = "unknown"
 )
 
+var (
+	listenAddress            = flag.String("web.listen-address", ":9001", "Address on which to expose metrics.")
+	metricsPath              = flag.String("web.metrics-path", "/metrics", "Path under which to expose metrics.")
+	probePath                = flag.String("web.probe-path", "/probe", "Path under which to expose the LMQ probe endpoint.")
+	lmqUri                   = flag.String("lmq.uri", "http://localhost:9980/stats", "LMQ URI to scrape in single-target mode. Its metrics are exposed on web.metrics-path alongside the exporter's own process metrics; set to the empty string to rely solely on web.probe-path.")
+	lmqTimeout               = flag.Duration("lmq.timeout", 5*time.Second, "Timeout for requests to LMQ.")
+	lmqRetries               = flag.Int("lmq.retries", 0, "Number of retries on a failed scrape request.")
+	lmqTLSCAFile             = flag.String("lmq.tls-ca-file", "", "Path to a CA file used to verify the LMQ server certificate.")
+	lmqTLSInsecureSkipVerify = flag.Bool("lmq.tls-insecure-skip-verify", false, "Skip LMQ server certificate verification.")
+	lmqBasicAuthUser         = flag.String("lmq.basic-auth-user", "", "Username for HTTP basic auth against LMQ.")
+	lmqBasicAuthPasswordFile = flag.String("lmq.basic-auth-password-file", "", "Path to a file containing the password for HTTP basic auth against LMQ.")
+	queueInclude             = flag.String("collector.queue-include", "", "Regular expression of queue names to include. Queues not matching are skipped.")
+	queueExclude             = flag.String("collector.queue-exclude", "", "Regular expression of queue names to exclude.")
+	_                        = flag.Duration("collector.min-interval", 5*time.Second, "Deprecated, no longer used: the collector now fetches fresh stats on every scrape. Kept so existing command lines don't fail to parse.")
+)
+
+var (
+	httpClient        *http.Client
+	basicAuthPassword string
+	queueIncludeRe    *regexp.Regexp
+	queueExcludeRe    *regexp.Regexp
+)
+
+// targetState holds the _total counters (scrape_errors_total,
+// queues_filtered_total) for a single probed target, so that they accumulate
+// across requests instead of resetting to zero on every probe. Unlike an
+// lmqCollector, a targetState is never request-scoped: it is only ever
+// touched through atomic operations, so it is safe to share across
+// concurrent probes of the same target.
+type targetState struct {
+	scrapeErrors   uint64
+	queuesFiltered uint64
+}
+
+// maxTargetStates bounds the number of distinct targets whose counters are
+// retained, so that a churning or mistyped target query parameter can't grow
+// the map without bound.
+const maxTargetStates = 10000
+
+var (
+	targetStatesMu sync.Mutex
+	targetStates   = map[string]*targetState{}
+)
+
+// stateForTarget returns the shared targetState for target, creating one if
+// this is the first time it has been probed. Once maxTargetStates is
+// reached, previously-unseen targets get a fresh, unshared state instead of
+// growing the map further; their counters simply won't persist across
+// requests.
+func stateForTarget(target string) *targetState {
+	targetStatesMu.Lock()
+	defer targetStatesMu.Unlock()
+	if s, ok := targetStates[target]; ok {
+		return s
+	}
+	if len(targetStates) >= maxTargetStates {
+		log.Printf("targetStates cache full (%d entries); not retaining counters for target %q", maxTargetStates, target)
+		return &targetState{}
+	}
+	s := &targetState{}
+	targetStates[target] = s
+	return s
+}
+
 type lmqStats struct {
 	Queues map[string]*queueStats
 }
@@ -47,142 +121,388 @@ type queueStats struct {
 }
 
 type lmqCollector struct {
-	uri             string
-	interval        time.Duration
-	expired         time.Time
-	m               sync.Mutex
-	size            *prometheus.GaugeVec
-	memory          *prometheus.GaugeVec
-	push            *prometheus.CounterVec
-	pull            *prometheus.CounterVec
-	retentionMin    *prometheus.GaugeVec
-	retentionMax    *prometheus.GaugeVec
-	retentionMean   *prometheus.GaugeVec
-	retentionMedian *prometheus.GaugeVec
-}
-
-func newLMQCollector(uri string, interval time.Duration) *lmqCollector {
+	uri            string
+	retries        int
+	basicAuthUser  string
+	basicAuthPass  string
+	client         *http.Client
+	includeRe      *regexp.Regexp
+	excludeRe      *regexp.Regexp
+	retryBackoff   time.Duration
+	counters       *targetState
+
+	// primed and the cached* fields let probe() fetch stats once and have
+	// the following Collect() (triggered by promhttp.HandlerFor in the same
+	// request) reuse that result instead of scraping LMQ again. This is
+	// safe without a lock because each lmqCollector used with probe() is
+	// built fresh per request (see probeHandler) and is therefore only ever
+	// touched by the single goroutine handling that request.
+	primed         bool
+	cachedStats    *lmqStats
+	cachedErr      error
+	cachedDuration time.Duration
+
+	sizeDesc           *prometheus.Desc
+	memoryDesc         *prometheus.Desc
+	pushDesc           *prometheus.Desc
+	pullDesc           *prometheus.Desc
+	retentionMinDesc   *prometheus.Desc
+	retentionMaxDesc   *prometheus.Desc
+	retentionDesc      *prometheus.Desc
+	upDesc             *prometheus.Desc
+	scrapeDurationDesc *prometheus.Desc
+	scrapeErrorsDesc   *prometheus.Desc
+	queuesFilteredDesc *prometheus.Desc
+	queuesScrapedDesc  *prometheus.Desc
+}
+
+// newHTTPClient builds a *http.Client suitable for repeated scraping of LMQ:
+// a bounded dial/request timeout, connection reuse and, when configured, TLS
+// verification against a custom CA.
+func newHTTPClient(timeout time.Duration, caFile string, insecureSkipVerify bool) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile != "" {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("failed to parse CA certificates from %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := &http.Transport{
+		Dial:                dialer.Dial,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: timeout,
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// readPasswordFile reads and trims the password used for HTTP basic auth.
+func readPasswordFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func newLMQCollector(uri string) *lmqCollector {
 	labelNames := []string{"queue"}
 	return &lmqCollector{
-		uri:      uri,
-		interval: interval,
-		size: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Subsystem: "queue",
-			Name:      "size",
-			Help:      "Number of messages currently in the queue.",
-		}, labelNames),
-		memory: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Subsystem: "queue",
-			Name:      "memory_bytes",
-			Help:      "Used memory in bytes.",
-		}, labelNames),
-		push: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: namespace,
-			Subsystem: "queue",
-			Name:      "push",
-			Help:      "Number of messages pushed to the queue.",
-		}, labelNames),
-		pull: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: namespace,
-			Subsystem: "queue",
-			Name:      "pull",
-			Help:      "Number of messages pulled from the queue.",
-		}, labelNames),
-		retentionMin: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Subsystem: "queue",
-			Name:      "retention_min",
-			Help:      "The minimum retention time in seconds.",
-		}, labelNames),
-		retentionMax: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Subsystem: "queue",
-			Name:      "retention_max",
-			Help:      "The maximum retention time in seconds.",
-		}, labelNames),
-		retentionMean: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Subsystem: "queue",
-			Name:      "retention_mean",
-			Help:      "Mean time of retention times in seconds.",
-		}, labelNames),
-		retentionMedian: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Subsystem: "queue",
-			Name:      "retention_median",
-			Help:      "A median of retention times in seconds.",
-		}, labelNames),
+		uri:          uri,
+		retries:      *lmqRetries,
+		retryBackoff: time.Second,
+		counters:     &targetState{},
+		sizeDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "size"),
+			"Number of messages currently in the queue.",
+			labelNames, nil,
+		),
+		memoryDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "memory_bytes"),
+			"Used memory in bytes.",
+			labelNames, nil,
+		),
+		pushDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "push"),
+			"Number of messages pushed to the queue.",
+			labelNames, nil,
+		),
+		pullDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "pull"),
+			"Number of messages pulled from the queue.",
+			labelNames, nil,
+		),
+		retentionMinDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "retention_min"),
+			"The minimum retention time in seconds.",
+			labelNames, nil,
+		),
+		retentionMaxDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "retention_max"),
+			"The maximum retention time in seconds.",
+			labelNames, nil,
+		),
+		retentionDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "retention_seconds"),
+			"Summary of message retention times in seconds.",
+			labelNames, nil,
+		),
+		upDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "up"),
+			"Whether the last scrape of LMQ was successful.",
+			nil, nil,
+		),
+		scrapeDurationDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "scrape_duration_seconds"),
+			"Time it took to scrape LMQ.",
+			nil, nil,
+		),
+		scrapeErrorsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "scrape_errors_total"),
+			"Number of errors while scraping LMQ.",
+			nil, nil,
+		),
+		queuesFilteredDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "queues_filtered_total"),
+			"Number of queues skipped due to collector.queue-include/collector.queue-exclude filters.",
+			nil, nil,
+		),
+		queuesScrapedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "queues_scraped"),
+			"Number of queues included in the last scrape.",
+			nil, nil,
+		),
 	}
 }
 
 func (c *lmqCollector) Describe(ch chan<- *prometheus.Desc) {
-	c.size.Describe(ch)
-	c.memory.Describe(ch)
-	c.push.Describe(ch)
-	c.pull.Describe(ch)
-	c.retentionMin.Describe(ch)
-	c.retentionMax.Describe(ch)
-	c.retentionMean.Describe(ch)
-	c.retentionMedian.Describe(ch)
+	ch <- c.sizeDesc
+	ch <- c.memoryDesc
+	ch <- c.pushDesc
+	ch <- c.pullDesc
+	ch <- c.retentionMinDesc
+	ch <- c.retentionMaxDesc
+	ch <- c.retentionDesc
+	ch <- c.upDesc
+	ch <- c.scrapeDurationDesc
+	ch <- c.scrapeErrorsDesc
+	ch <- c.queuesFilteredDesc
+	ch <- c.queuesScrapedDesc
 }
 
+// Collect fetches a fresh snapshot of LMQ stats and emits it as const
+// metrics. No per-queue state is kept between scrapes (only the _total
+// counters in c.counters accumulate, plus what probe primes for a single
+// request), so queues that disappear from LMQ simply stop being reported
+// instead of lingering with stale labels.
 func (c *lmqCollector) Collect(ch chan<- prometheus.Metric) {
-	if err := c.updateStats(); err != nil {
+	var stats *lmqStats
+	var err error
+	var duration time.Duration
+	if c.primed {
+		stats, err, duration = c.cachedStats, c.cachedErr, c.cachedDuration
+		c.primed = false
+	} else {
+		start := time.Now()
+		stats, err = c.fetchStats()
+		duration = time.Since(start)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds())
+	if err != nil {
 		log.Printf("Failed to update metrics: %v", err)
+		atomic.AddUint64(&c.counters.scrapeErrors, 1)
+		ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 0)
+		ch <- prometheus.MustNewConstMetric(c.scrapeErrorsDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&c.counters.scrapeErrors)))
 		return
 	}
-	c.size.Collect(ch)
-	c.memory.Collect(ch)
-	c.push.Collect(ch)
-	c.pull.Collect(ch)
-	c.retentionMin.Collect(ch)
-	c.retentionMax.Collect(ch)
-	c.retentionMean.Collect(ch)
-	c.retentionMedian.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(c.scrapeErrorsDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&c.counters.scrapeErrors)))
+
+	var scraped int
+	for name, q := range stats.Queues {
+		if !queueAllowed(name, c.includeRe, c.excludeRe) {
+			atomic.AddUint64(&c.counters.queuesFiltered, 1)
+			continue
+		}
+		scraped++
+
+		ch <- prometheus.MustNewConstMetric(c.sizeDesc, prometheus.GaugeValue, float64(q.Size), name)
+		ch <- prometheus.MustNewConstMetric(c.memoryDesc, prometheus.GaugeValue, float64(q.Memory), name)
+		ch <- prometheus.MustNewConstMetric(c.pushDesc, prometheus.CounterValue, float64(q.Stats.Push.Count), name)
+		ch <- prometheus.MustNewConstMetric(c.pullDesc, prometheus.CounterValue, float64(q.Stats.Pull.Count), name)
+		ch <- prometheus.MustNewConstMetric(c.retentionMinDesc, prometheus.GaugeValue, q.Stats.Retention.Min, name)
+		ch <- prometheus.MustNewConstMetric(c.retentionMaxDesc, prometheus.GaugeValue, q.Stats.Retention.Max, name)
+
+		count, sum, quantiles := retentionSummary(q.Stats.Pull.Count, q.Stats.Retention.Mean, q.Stats.Retention.Median)
+		ch <- prometheus.MustNewConstSummary(c.retentionDesc, count, sum, quantiles, name)
+	}
+	ch <- prometheus.MustNewConstMetric(c.queuesFilteredDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&c.counters.queuesFiltered)))
+	ch <- prometheus.MustNewConstMetric(c.queuesScrapedDesc, prometheus.GaugeValue, float64(scraped))
 }
 
-func (c *lmqCollector) updateStats() error {
-	c.m.Lock()
-	defer c.m.Unlock()
-	if time.Now().Before(c.expired) {
-		return nil
+// queueAllowed reports whether a queue named name should be scraped given
+// the collector.queue-include/collector.queue-exclude filters. Exclude takes
+// precedence: a queue matching both is skipped.
+func queueAllowed(name string, include, exclude *regexp.Regexp) bool {
+	if exclude != nil && exclude.MatchString(name) {
+		return false
+	}
+	if include != nil && !include.MatchString(name) {
+		return false
 	}
+	return true
+}
+
+// retentionSummary derives the count, sum and quantiles needed to report a
+// queue's retention times as a Prometheus summary from the aggregates LMQ
+// exposes (it does not give us the underlying samples).
+func retentionSummary(pullCount int, mean, median float64) (count uint64, sum float64, quantiles map[float64]float64) {
+	count = uint64(pullCount)
+	sum = mean * float64(count)
+	quantiles = map[float64]float64{0.5: median}
+	return count, sum, quantiles
+}
 
-	resp, err := http.Get(c.uri)
+// fetchStats issues the LMQ stats request, retrying up to c.retries times on
+// failure.
+func (c *lmqCollector) fetchStats() (*lmqStats, error) {
+	req, err := http.NewRequest("GET", c.uri, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	if c.basicAuthUser != "" {
+		req.SetBasicAuth(c.basicAuthUser, c.basicAuthPass)
 	}
-	var stats lmqStats
-	if err = json.Unmarshal(b, &stats); err != nil {
-		return err
+
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(c.retryBackoff)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, c.uri)
+			continue
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var stats lmqStats
+		if err = json.Unmarshal(b, &stats); err != nil {
+			lastErr = err
+			continue
+		}
+		return &stats, nil
 	}
+	return nil, lastErr
+}
 
-	c.expired = time.Now().Add(c.interval)
-	for name, q := range stats.Queues {
-		c.size.WithLabelValues(name).Set(float64(q.Size))
-		c.memory.WithLabelValues(name).Set(float64(q.Memory))
-		c.push.WithLabelValues(name).Set(float64(q.Stats.Push.Count))
-		c.pull.WithLabelValues(name).Set(float64(q.Stats.Pull.Count))
-		c.retentionMin.WithLabelValues(name).Set(q.Stats.Retention.Min)
-		c.retentionMax.WithLabelValues(name).Set(q.Stats.Retention.Max)
-		c.retentionMean.WithLabelValues(name).Set(q.Stats.Retention.Mean)
-		c.retentionMedian.WithLabelValues(name).Set(q.Stats.Retention.Median)
+// newConfiguredLMQCollector builds a collector for uri using the shared HTTP
+// client, basic auth credentials, and queue filters set up in main.
+func newConfiguredLMQCollector(uri string) *lmqCollector {
+	c := newLMQCollector(uri)
+	c.client = httpClient
+	c.basicAuthUser = *lmqBasicAuthUser
+	c.basicAuthPass = basicAuthPassword
+	c.includeRe = queueIncludeRe
+	c.excludeRe = queueExcludeRe
+	return c
+}
+
+// probe fetches LMQ stats once and primes the collector so the next Collect
+// (triggered by promhttp.HandlerFor during the same request) reuses this
+// result instead of scraping LMQ a second time.
+func (c *lmqCollector) probe() error {
+	start := time.Now()
+	c.cachedStats, c.cachedErr = c.fetchStats()
+	c.cachedDuration = time.Since(start)
+	c.primed = true
+	return c.cachedErr
+}
+
+// probeHandler scrapes the LMQ instance named by the target query parameter
+// into a fresh registry, following the blackbox/snmp_exporter multi-target
+// pattern. This lets a single lmq_exporter process monitor a fleet of LMQ
+// brokers via Prometheus relabel_configs rather than binding to one
+// --lmq.uri. A fresh lmqCollector is built for every request, so the only
+// state shared across concurrent probes of the same target is its
+// targetState counters, which are updated atomically.
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
 	}
-	return nil
+
+	reg := prometheus.NewRegistry()
+	probeSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lmq_probe_success",
+		Help: "Displays whether or not the probe was a success.",
+	})
+	reg.MustRegister(probeSuccess)
+
+	c := newConfiguredLMQCollector("http://" + target + "/stats")
+	c.counters = stateForTarget(target)
+	reg.MustRegister(c)
+
+	if err := c.probe(); err != nil {
+		probeSuccess.Set(0)
+	} else {
+		probeSuccess.Set(1)
+	}
+
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 }
 
 func main() {
 	flag.Parse()
-	c := newLMQCollector(*lmqUri, *minInterval)
-	prometheus.MustRegister(c)
-	http.Handle(*metricsPath, prometheus.Handler())
+
+	client, err := newHTTPClient(*lmqTimeout, *lmqTLSCAFile, *lmqTLSInsecureSkipVerify)
+	if err != nil {
+		log.Fatalf("Failed to build LMQ HTTP client: %v", err)
+	}
+	httpClient = client
+
+	if *lmqBasicAuthPasswordFile != "" {
+		pass, err := readPasswordFile(*lmqBasicAuthPasswordFile)
+		if err != nil {
+			log.Fatalf("Failed to read LMQ basic auth password file: %v", err)
+		}
+		basicAuthPassword = pass
+	}
+
+	if *queueInclude != "" {
+		re, err := regexp.Compile(*queueInclude)
+		if err != nil {
+			log.Fatalf("Invalid collector.queue-include regular expression: %v", err)
+		}
+		queueIncludeRe = re
+	}
+	if *queueExclude != "" {
+		re, err := regexp.Compile(*queueExclude)
+		if err != nil {
+			log.Fatalf("Invalid collector.queue-exclude regular expression: %v", err)
+		}
+		queueExcludeRe = re
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	reg.MustRegister(collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsAll)))
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lmq_exporter_build_info",
+		Help: "A metric with a constant '1' value labeled by version, revision, branch, and goversion from which lmq_exporter was built.",
+		ConstLabels: prometheus.Labels{
+			"version":   version,
+			"revision":  revision,
+			"branch":    branch,
+			"goversion": runtime.Version(),
+		},
+	})
+	buildInfo.Set(1)
+	reg.MustRegister(buildInfo)
+
+	if *lmqUri != "" {
+		reg.MustRegister(newConfiguredLMQCollector(*lmqUri))
+	}
+
+	http.HandleFunc(*probePath, probeHandler)
+	http.Handle(*metricsPath, promhttp.HandlerFor(reg, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}))
 	h := webutil.Recoverer(http.DefaultServeMux, os.Stderr)
 
 	log.Printf("Starting lmq_exporter at %s", *listenAddress)
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestQueueAllowed(t *testing.T) {
+	cases := []struct {
+		name    string
+		include string
+		exclude string
+		queue   string
+		want    bool
+	}{
+		{"no filters", "", "", "orders", true},
+		{"include match", "^orders$", "", "orders", true},
+		{"include no match", "^orders$", "", "payments", false},
+		{"exclude match", "", "^tmp-", "tmp-123", false},
+		{"exclude no match", "", "^tmp-", "orders", true},
+		{"exclude takes precedence over include", "^orders$", "^orders$", "orders", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var include, exclude *regexp.Regexp
+			if c.include != "" {
+				include = regexp.MustCompile(c.include)
+			}
+			if c.exclude != "" {
+				exclude = regexp.MustCompile(c.exclude)
+			}
+			if got := queueAllowed(c.queue, include, exclude); got != c.want {
+				t.Errorf("queueAllowed(%q) = %v, want %v", c.queue, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRetentionSummary(t *testing.T) {
+	count, sum, quantiles := retentionSummary(10, 2.5, 2.0)
+	if count != 10 {
+		t.Errorf("count = %d, want 10", count)
+	}
+	if sum != 25 {
+		t.Errorf("sum = %v, want 25", sum)
+	}
+	if quantiles[0.5] != 2.0 {
+		t.Errorf("quantiles[0.5] = %v, want 2.0", quantiles[0.5])
+	}
+}
+
+func TestFetchStatsRetriesUntilSuccess(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"Queues":{"a":{"Size":1}}}`))
+	}))
+	defer srv.Close()
+
+	c := newLMQCollector(srv.URL)
+	c.client = srv.Client()
+	c.retries = 1
+	c.retryBackoff = 0
+
+	stats, err := c.fetchStats()
+	if err != nil {
+		t.Fatalf("fetchStats() error = %v", err)
+	}
+	if stats.Queues["a"].Size != 1 {
+		t.Errorf("Queues[a].Size = %d, want 1", stats.Queues["a"].Size)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
+
+func TestFetchStatsNonOKStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c := newLMQCollector(srv.URL)
+	c.client = srv.Client()
+
+	if _, err := c.fetchStats(); err == nil {
+		t.Fatal("fetchStats() error = nil, want non-2xx error")
+	}
+}
+
+func TestFetchStatsExhaustsRetries(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := newLMQCollector(srv.URL)
+	c.client = srv.Client()
+	c.retries = 1
+	c.retryBackoff = 0
+
+	if _, err := c.fetchStats(); err == nil {
+		t.Fatal("fetchStats() error = nil, want error after exhausting retries")
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2 (initial + 1 retry)", attempts)
+	}
+}